@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+)
+
+// errLine wraps an error encountered while processing a particular entry
+// with the source location it was wrapped at, the path being processed, and
+// the operation that produced it (e.g. "stat", "readdir", "lookupuid"). This
+// turns an opaque bare error string into something that points back at both
+// the offending entry and the call site in ls itself.
+type errLine struct {
+	file string
+	line int
+
+	path string
+	op   string
+
+	err error
+}
+
+// wrapErrLine captures the caller's source location (one frame up from
+// wrapErrLine itself, i.e. the fetchEntryInfoVisited call site) and returns
+// an *errLine annotating err with path and op. It returns nil if err is nil,
+// so call sites can wrap unconditionally.
+func wrapErrLine(path, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	_, file, line, ok := runtime.Caller(1)
+	if ok {
+		file = filepath.Base(file)
+	}
+
+	return &errLine{file: file, line: line, path: path, op: op, err: err}
+}
+
+func (e *errLine) Error() string {
+	return fmt.Sprintf("%s:%d: cannot access '%s' during %s: %s", e.file, e.line, e.path, e.op, e.err)
+}
+
+// Unwrap exposes the underlying error so errors.As/errors.Is (e.g. for
+// *fs.PathError or user.UnknownUserIdError) still see through the wrapper.
+func (e *errLine) Unwrap() error {
+	return e.err
+}
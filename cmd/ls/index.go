@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Rican7/gocoreutils/index"
+)
+
+// runReindex implements `ls --index=PATH --reindex=ROOT`.
+func runReindex() {
+	if config.indexPath == "" {
+		printErrorsAndExit(fmt.Errorf("ls: --reindex requires --index"))
+	}
+
+	if err := index.Reindex(config.reindexRoot, config.indexPath); err != nil {
+		printErrorsAndExit(err)
+	}
+}
+
+// runApplyDiff implements `ls --index=PATH --index-root=ROOT --apply-diff=FILE`.
+func runApplyDiff() {
+	if config.indexPath == "" || config.indexRoot == "" {
+		printErrorsAndExit(fmt.Errorf("ls: --apply-diff requires --index and --index-root"))
+	}
+
+	if err := index.ApplyDiff(config.indexRoot, config.indexPath, config.applyDiffFile); err != nil {
+		printErrorsAndExit(err)
+	}
+}
+
+// runIndexedList implements `ls --index=PATH [paths...]`, answering each
+// path from the database instead of walking the live filesystem. Paths are
+// interpreted relative to the indexed root; the root itself is "" or ".".
+// It reuses printEntries/printLongEntryInfo so --index output is formatted
+// identically to a live listing of the same subtree.
+func runIndexedList(paths []string) {
+	if len(paths) == 0 {
+		paths = []string{""}
+	}
+
+	entries := make([]entryInfo, 0, len(paths))
+	errs := make([]error, 0)
+
+	for _, p := range paths {
+		entry, err := indexEntryFor(config.indexPath, indexedPrefix(p), config.recursive)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		entry.directRequest = true
+		entries = append(entries, entry)
+	}
+
+	if len(errs) > 0 {
+		printErrors(errs...)
+	}
+
+	entryWriter := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+
+	printEntries(entryWriter, entries, config.recursive)
+
+	if err := entryWriter.Flush(); err != nil {
+		errs = append(errs, err)
+		printErrors(err)
+	}
+
+	if len(errs) > 0 {
+		os.Exit(2)
+	}
+}
+
+// indexedPrefix normalizes a command-line path argument into the
+// "/"-separated, root-relative key that index.Record.Name uses.
+func indexedPrefix(p string) string {
+	p = path.Clean(filepath.ToSlash(p))
+
+	if p == "." || p == "/" {
+		return ""
+	}
+
+	return strings.TrimPrefix(p, "/")
+}
+
+// isWithin reports whether name is prefix itself or lies somewhere in its
+// subtree. matches, unlike strings.TrimPrefix alone, doesn't silently treat
+// an unrelated record (e.g. the root "" record sitting alongside a deeper
+// prefix's matches in the same shared slice) as if it were inside prefix.
+func isWithin(prefix, name string) bool {
+	if prefix == "" {
+		return true
+	}
+
+	return name == prefix || strings.HasPrefix(name, prefix+"/")
+}
+
+// indexEntryFor materializes the entryInfo tree for prefix from a single
+// Query: just prefix plus its direct children for a plain listing, or the
+// whole subtree when recurse (-R) needs to walk further down. This is what
+// keeps a plain `ls --index=PATH` from pulling the entire database into
+// memory the way querying with an unbounded depth would.
+func indexEntryFor(dbPath, prefix string, recurse bool) (entryInfo, error) {
+	depth := 1
+	if recurse {
+		depth = index.AllDepths
+	}
+
+	matches, err := index.Query(dbPath, prefix, depth)
+	if err != nil {
+		return entryInfo{}, err
+	}
+
+	var self index.Record
+	found := prefix == ""
+
+	for _, rec := range matches {
+		if rec.Name == prefix {
+			self = rec
+			found = true
+
+			break
+		}
+	}
+
+	if !found {
+		return entryInfo{}, fmt.Errorf("ls: %q: not found in index", prefix)
+	}
+	if prefix == "" {
+		self = index.Record{Name: "", Type: index.TypeDir}
+	}
+
+	entry := newEntryInfo(nil, prefix, indexFileInfo{self})
+	populateIndexOwner(&entry, self)
+
+	if self.Type == index.TypeDir {
+		entry.subEntries = indexChildEntries(dbPath, matches, prefix)
+		setPrecomputedTotal(&entry, dbPath, prefix)
+	}
+
+	return entry, nil
+}
+
+// indexChildEntries pulls the entries immediately under prefix out of
+// matches (everything Query returned for the listing), recursing into any that are
+// themselves directories so -R doesn't need another pass over the database.
+func indexChildEntries(dbPath string, matches []index.Record, prefix string) []entryInfo {
+	var children []entryInfo
+
+	for _, rec := range matches {
+		if rec.Name == prefix || !isWithin(prefix, rec.Name) {
+			continue
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(rec.Name, prefix), "/")
+		if strings.Contains(rel, "/") {
+			continue // not a direct child
+		}
+
+		child := newEntryInfo(nil, rec.Name, indexFileInfo{rec})
+		populateIndexOwner(&child, rec)
+
+		if rec.Type == index.TypeDir {
+			child.subEntries = indexChildEntries(dbPath, matches, rec.Name)
+			setPrecomputedTotal(&child, dbPath, rec.Name)
+		}
+
+		children = append(children, child)
+	}
+
+	return children
+}
+
+func setPrecomputedTotal(e *entryInfo, dbPath, name string) {
+	total, err := index.LookupDirSize(dbPath, name)
+	if err != nil {
+		return
+	}
+
+	blocks := uint(total)
+	e.precomputedTotalBlocks = &blocks
+}
+
+func populateIndexOwner(e *entryInfo, rec index.Record) {
+	e.uID = int(rec.UID)
+	e.gID = int(rec.GID)
+
+	if userInfo, err := user.LookupId(strconv.Itoa(e.uID)); err == nil {
+		e.user = userInfo
+	}
+
+	if groupInfo, err := user.LookupGroupId(strconv.Itoa(e.gID)); err == nil {
+		e.group = groupInfo
+	}
+}
+
+// indexFileInfo adapts an index.Record to fs.FileInfo, so an index-backed
+// entry can flow through the same entryInfo/printEntries/printLongEntryInfo
+// machinery as a live filesystem listing.
+type indexFileInfo struct {
+	rec index.Record
+}
+
+func (i indexFileInfo) Name() string { return path.Base(i.rec.Name) }
+
+func (i indexFileInfo) Size() int64 { return i.rec.Size }
+
+func (i indexFileInfo) Mode() fs.FileMode { return fs.FileMode(i.rec.Mode) }
+
+func (i indexFileInfo) ModTime() time.Time { return i.rec.MTime }
+
+func (i indexFileInfo) IsDir() bool { return i.rec.Type == index.TypeDir }
+
+func (i indexFileInfo) Sys() any { return nil }
@@ -8,6 +8,7 @@ import (
 	"io/fs"
 	"os"
 	"os/user"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -24,9 +25,138 @@ const (
 var config = struct {
 	blockSize      uint
 	formatLongList bool
+	recursive      bool
+
+	showAll       bool
+	showAlmostAll bool
+	sortTime      bool
+	sortSize      bool
+	noSort        bool
+	reverse       bool
+	sortFlag      string
+
+	indexPath     string
+	indexRoot     string
+	reindexRoot   string
+	applyDiffFile string
 }{}
 
+// sortMode selects which key sortEntries orders by. The zero value,
+// sortByName, is the GNU-default lexicographic (case-insensitive) order.
+type sortMode int
+
+const (
+	sortByName sortMode = iota
+	sortByTime
+	sortBySize
+	sortNone
+)
+
+// sortConfig is the resolved form of the -t/-S/-U/-r/--sort flags. Every
+// printEntries call builds it from config with resolveSortConfig, so every
+// caller (live listing, --index listing) sorts consistently.
+type sortConfig struct {
+	mode    sortMode
+	reverse bool
+}
+
+// resolveSortConfig turns the raw flag values in config into a sortConfig.
+// --sort=MODE takes precedence over the single-letter flags; among those,
+// -U (no sort) wins over -t and -S, matching the GNU behavior that the most
+// specific/overriding option applies regardless of flag declaration order.
+func resolveSortConfig() sortConfig {
+	cfg := sortConfig{reverse: config.reverse}
+
+	switch config.sortFlag {
+	case "name":
+		cfg.mode = sortByName
+		return cfg
+	case "time":
+		cfg.mode = sortByTime
+		return cfg
+	case "size":
+		cfg.mode = sortBySize
+		return cfg
+	case "none":
+		cfg.mode = sortNone
+		return cfg
+	}
+
+	switch {
+	case config.noSort:
+		cfg.mode = sortNone
+	case config.sortTime:
+		cfg.mode = sortByTime
+	case config.sortSize:
+		cfg.mode = sortBySize
+	default:
+		cfg.mode = sortByName
+	}
+
+	return cfg
+}
+
+// sortEntries orders entries in place according to cfg. It's the single
+// sort code path shared by the direct-file list and every directory's
+// sub-entries in printEntries, so any backend (see the pluggable fs.FS)
+// inherits sorting without re-implementing it.
+func sortEntries(entries []entryInfo, cfg sortConfig) {
+	if cfg.mode == sortNone {
+		if cfg.reverse {
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+		}
+
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		var less bool
+
+		switch cfg.mode {
+		case sortByTime:
+			less = entries[i].ModTime().After(entries[j].ModTime())
+		case sortBySize:
+			less = entries[i].Size() > entries[j].Size()
+		default:
+			less = strings.ToLower(entries[i].path) < strings.ToLower(entries[j].path)
+		}
+
+		if cfg.reverse {
+			return !less
+		}
+
+		return less
+	})
+}
+
+// showHidden reports whether entries starting with "." should be kept.
+// ls's fs.ReadDir-based traversal never yields synthetic "." or ".."
+// entries, so -a and -A are equivalent here: both simply stop filtering
+// dotfiles, rather than -a additionally injecting "." and "..".
+func showHidden() bool {
+	return config.showAll || config.showAlmostAll
+}
+
+// dirKey identifies a directory by device and inode, used to detect symlink
+// loops when recursing.
+type dirKey struct {
+	dev uint64
+	ino uint64
+}
+
+func dirKeyFor(info fs.FileInfo) (dirKey, bool) {
+	sysStat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return dirKey{}, false
+	}
+
+	return dirKey{dev: uint64(sysStat.Dev), ino: sysStat.Ino}, true
+}
+
 type entryInfo struct {
+	fsys fs.FS
 	path string
 
 	fs.FileInfo
@@ -41,11 +171,17 @@ type entryInfo struct {
 	user  *user.User
 	group *user.Group
 
+	// precomputedTotalBlocks, when set, overrides summing subEntries in
+	// blocksForSize. Only --index listings set this, using the dirsize
+	// sidecar instead of re-summing the subtree.
+	precomputedTotalBlocks *uint
+
 	subEntries []entryInfo
 }
 
-func newEntryInfo(path string, fsInfo fs.FileInfo) entryInfo {
+func newEntryInfo(fsys fs.FS, path string, fsInfo fs.FileInfo) entryInfo {
 	return entryInfo{
+		fsys: fsys,
 		path: path,
 
 		FileInfo: fsInfo,
@@ -56,8 +192,29 @@ func newEntryInfo(path string, fsInfo fs.FileInfo) entryInfo {
 }
 
 func (e *entryInfo) SafeName() string {
-	name := e.path
+	return safeName(e.path)
+}
 
+// listName is the name to print for e in any listing: the full (safe) path
+// as given on the command line for a direct request, or just the base name
+// for an entry reached by descending into a directory (where e.path is the
+// full path built up by fetchEntryInfoVisited, not what the user typed).
+func (e *entryInfo) listName() string {
+	if e.directRequest {
+		return e.SafeName()
+	}
+
+	return e.displayName()
+}
+
+// displayName is like SafeName, but for an entry listed as a member of a
+// directory, where only the base name (not the full path used to reach it)
+// should be shown.
+func (e *entryInfo) displayName() string {
+	return safeName(filepath.Base(e.path))
+}
+
+func safeName(name string) string {
 	if strings.Contains(name, " ") {
 		name = fmt.Sprintf("'%s'", name)
 	}
@@ -69,6 +226,10 @@ func (e *entryInfo) blocksForSize(blockSize uint) uint {
 	factor := blockSize / 512
 
 	if e.IsDir() {
+		if e.precomputedTotalBlocks != nil {
+			return *e.precomputedTotalBlocks / factor
+		}
+
 		var total uint
 
 		for _, entry := range e.subEntries {
@@ -84,31 +245,66 @@ func (e *entryInfo) blocksForSize(blockSize uint) uint {
 func init() {
 	flag.UintVar(&config.blockSize, "block-size", 1024, "scale sizes by SIZE before printing them")
 	flag.BoolVar(&config.formatLongList, "l", false, "use a long listing format")
-
-	flag.Parse()
+	flag.BoolVar(&config.recursive, "R", false, "list subdirectories recursively")
+
+	flag.BoolVar(&config.showAll, "a", false, "do not ignore entries starting with .")
+	flag.BoolVar(&config.showAlmostAll, "A", false, "do not ignore entries starting with . (same effect as -a here)")
+	flag.BoolVar(&config.sortTime, "t", false, "sort by modification time, newest first")
+	flag.BoolVar(&config.sortSize, "S", false, "sort by file size, largest first")
+	flag.BoolVar(&config.noSort, "U", false, "do not sort; list entries in directory order")
+	flag.BoolVar(&config.reverse, "r", false, "reverse order while sorting")
+	flag.StringVar(&config.sortFlag, "sort", "", "sort by WORD instead of name: name, time, size, none")
+
+	flag.StringVar(&config.indexPath, "index", "", "list from an on-disk index database instead of the live filesystem")
+	flag.StringVar(&config.indexRoot, "index-root", "", "root the index database was built from (required with --apply-diff)")
+	flag.StringVar(&config.reindexRoot, "reindex", "", "rebuild the database given by --index from this root")
+	flag.StringVar(&config.applyDiffFile, "apply-diff", "", "patch the database given by --index with a zfs-diff-style FILE")
 }
 
 func main() {
+	flag.Parse()
+
 	paths := flag.Args()
 
+	if config.reindexRoot != "" {
+		runReindex()
+		return
+	}
+
+	if config.applyDiffFile != "" {
+		runApplyDiff()
+		return
+	}
+
+	if config.indexPath != "" {
+		runIndexedList(paths)
+		return
+	}
+
 	entries := make([]entryInfo, 0, len(paths))
 	errs := make([]error, 0)
-	for _, path := range paths {
-		info, err := os.Stat(path)
+	for _, rawPath := range paths {
+		fsys, path, err := resolveFS(rawPath)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		info, err := fs.Stat(fsys, path)
 		if err != nil {
 			// Collect errors for the end
 			errs = append(errs, err)
 		}
 
 		if info != nil {
-			entry := newEntryInfo(path, info)
+			entry := newEntryInfo(fsys, path, info)
 			entry.directRequest = true
 
 			entries = append(entries, entry)
 		}
 	}
 
-	errs = append(errs, fetchEntryInfo(entries, false)...)
+	errs = append(errs, fetchEntryInfo(entries, config.recursive)...)
 
 	if len(errs) > 0 {
 		printErrors(errs...)
@@ -116,7 +312,7 @@ func main() {
 
 	entryWriter := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
 
-	printEntries(entryWriter, entries, false)
+	printEntries(entryWriter, entries, config.recursive)
 
 	if err := entryWriter.Flush(); err != nil {
 		errs = append(errs, err)
@@ -129,20 +325,27 @@ func main() {
 }
 
 func fetchEntryInfo(entries []entryInfo, recurse bool) []error {
+	return fetchEntryInfoVisited(entries, recurse, make(map[dirKey]bool))
+}
+
+// fetchEntryInfoVisited does the actual work for fetchEntryInfo. visited
+// tracks the (dev, ino) of every directory already descended into, so that a
+// symlink loop can't send a recursive listing into an infinite regress.
+func fetchEntryInfoVisited(entries []entryInfo, recurse bool, visited map[dirKey]bool) []error {
 	errs := make([]error, 0)
 
 	for i := range entries {
-		if sysStat, ok := entries[i].Sys().(*syscall.Stat_t); ok {
-			entries[i].numLinks = int(sysStat.Nlink)
-			entries[i].numBlocks = uint(sysStat.Blocks)
-			entries[i].uID = int(sysStat.Uid)
-			entries[i].gID = int(sysStat.Gid)
+		if uid, gid, nlink, blocks, ok := ownerOf(entries[i].fsys, entries[i].path, entries[i].FileInfo); ok {
+			entries[i].uID = uid
+			entries[i].gID = gid
+			entries[i].numLinks = nlink
+			entries[i].numBlocks = blocks
 		}
 
 		if entries[i].uID != -1 {
 			userInfo, err := user.LookupId(strconv.Itoa(entries[i].uID))
 			if err != nil {
-				errs = append(errs, err)
+				errs = append(errs, wrapErrLine(entries[i].path, "lookupuid", err))
 			}
 
 			entries[i].user = userInfo
@@ -151,102 +354,160 @@ func fetchEntryInfo(entries []entryInfo, recurse bool) []error {
 		if entries[i].gID != -1 {
 			groupInfo, err := user.LookupGroupId(strconv.Itoa(entries[i].gID))
 			if err != nil {
-				errs = append(errs, err)
+				errs = append(errs, wrapErrLine(entries[i].path, "lookupgid", err))
 			}
 
 			entries[i].group = groupInfo
 		}
 
-		if entries[i].IsDir() {
-			dirEntries, err := os.ReadDir(entries[i].path)
-			if err != nil {
-				errs = append(errs, err)
+		// Only descend into a directory's contents for entries given
+		// directly on the command line, or everywhere once -R is in
+		// effect.
+		if !entries[i].IsDir() || (!entries[i].directRequest && !recurse) {
+			continue
+		}
+
+		if key, ok := dirKeyFor(entries[i].FileInfo); ok {
+			if visited[key] {
+				continue
 			}
 
-			entries[i].subEntries = make([]entryInfo, len(dirEntries))
-			for j, dirEntry := range dirEntries {
-				dirInfo, err := dirEntry.Info()
-				if err != nil {
-					errs = append(errs, err)
-				}
+			visited[key] = true
+		}
+
+		dirEntries, err := fs.ReadDir(entries[i].fsys, entries[i].path)
+		if err != nil {
+			errs = append(errs, wrapErrLine(entries[i].path, "readdir", err))
+		}
+
+		if !showHidden() {
+			dirEntries = visibleDirEntries(dirEntries)
+		}
+
+		entries[i].subEntries = make([]entryInfo, len(dirEntries))
+		for j, dirEntry := range dirEntries {
+			subPath := filepath.Join(entries[i].path, dirEntry.Name())
 
-				entries[i].subEntries[j] = newEntryInfo(dirEntry.Name(), dirInfo)
+			dirInfo, err := dirEntry.Info()
+			if err != nil {
+				errs = append(errs, wrapErrLine(subPath, "stat", err))
 			}
 
-			errs = append(errs, fetchEntryInfo(entries[i].subEntries, recurse)...)
+			entries[i].subEntries[j] = newEntryInfo(entries[i].fsys, subPath, dirInfo)
 		}
+
+		errs = append(errs, fetchEntryInfoVisited(entries[i].subEntries, recurse, visited)...)
+	}
+
+	return errs
+}
+
+// visibleDirEntries drops entries whose name starts with "." (the GNU
+// default), leaving dotfiles in only when -a or -A was given.
+func visibleDirEntries(dirEntries []fs.DirEntry) []fs.DirEntry {
+	visible := dirEntries[:0]
+
+	for _, dirEntry := range dirEntries {
+		if strings.HasPrefix(dirEntry.Name(), ".") {
+			continue
+		}
+
+		visible = append(visible, dirEntry)
 	}
 
-	return nil
+	return visible
 }
 
 func printEntries(writer io.Writer, entries []entryInfo, recurse bool) {
-	directFileEntries := make([]entryInfo, 0)
-	directFileNames := make([]string, 0)
-	otherEntries := make([]entryInfo, 0)
+	sortCfg := resolveSortConfig()
+
+	// Plain files are grouped into a single short-form list (or one line
+	// each under -l); directories get their own "name:\n" header and
+	// contents below. This split is by IsDir() alone, not directRequest:
+	// the subdirectories a recursive (-R) call hands back in
+	// (directRequest false) still each get their own header.
+	fileEntries := make([]entryInfo, 0)
+	dirEntries := make([]entryInfo, 0)
 
 	for _, entry := range entries {
 		switch {
-		case entry.directRequest && !entry.IsDir():
-			directFileEntries = append(directFileEntries, entry)
-			directFileNames = append(directFileNames, entry.SafeName())
+		case !entry.IsDir():
+			fileEntries = append(fileEntries, entry)
 		default:
-			otherEntries = append(otherEntries, entry)
+			dirEntries = append(dirEntries, entry)
 		}
 	}
 
+	sortEntries(fileEntries, sortCfg)
+
 	switch {
-	case !config.formatLongList && len(directFileNames) > 0:
-		sort.Strings(directFileNames)
-		fmt.Fprintln(writer, strings.Join(directFileNames, "\t\t\t"))
+	case !config.formatLongList && len(fileEntries) > 0:
+		fileNames := make([]string, len(fileEntries))
+		for i, entry := range fileEntries {
+			fileNames[i] = entry.listName()
+		}
+
+		fmt.Fprintln(writer, strings.Join(fileNames, "\t\t\t"))
 	case config.formatLongList:
-		for _, entry := range directFileEntries {
+		for _, entry := range fileEntries {
 			printLongEntryInfo(writer, entry)
 		}
 	}
 
-	for i, entry := range otherEntries {
+	for i, entry := range dirEntries {
 		if i > 0 {
 			fmt.Fprintln(writer)
 		}
 
 		// Entry header
-		if len(entries) > 1 {
+		if len(entries) > 1 || recurse {
 			fmt.Fprintf(writer, "%s:\n", entry.SafeName())
 		}
 		if config.formatLongList {
 			fmt.Fprintf(writer, "total %d\n", entry.blocksForSize(config.blockSize))
 		}
 
+		sortEntries(entry.subEntries, sortCfg)
+
 		switch {
 		case !config.formatLongList:
 			subFileNames := make([]string, len(entry.subEntries))
 			for j, subEntry := range entry.subEntries {
-				subFileNames[j] = subEntry.SafeName()
+				subFileNames[j] = subEntry.displayName()
 			}
 
 			if len(subFileNames) > 0 {
-				sort.Slice(subFileNames, func(i, j int) bool {
-					return strings.ToLower(subFileNames[i]) < strings.ToLower(subFileNames[j])
-				})
 				fmt.Fprintln(writer, strings.Join(subFileNames, "\t\t\t"))
 			}
 		case config.formatLongList:
-			sort.Slice(entry.subEntries, func(i, j int) bool {
-				return strings.ToLower(entry.subEntries[i].path) < strings.ToLower(entry.subEntries[j].path)
-			})
-
 			for _, entry := range entry.subEntries {
 				printLongEntryInfo(writer, entry)
 			}
 		}
 
 		if recurse {
-			printEntries(writer, entry.subEntries, recurse)
+			printEntries(writer, subdirEntries(entry.subEntries), recurse)
 		}
 	}
 }
 
+// subdirEntries returns just the directories in entries, dropping the
+// plain files. A recursive listing has already printed those files inline
+// under their parent's header above; handing the unfiltered entries back
+// into printEntries would print them a second time, as their own bogus
+// top-level file list.
+func subdirEntries(entries []entryInfo) []entryInfo {
+	var subdirs []entryInfo
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			subdirs = append(subdirs, entry)
+		}
+	}
+
+	return subdirs
+}
+
 func printLongEntryInfo(writer io.Writer, entry entryInfo) {
 	line := entry.Mode().String()
 
@@ -273,7 +534,7 @@ func printLongEntryInfo(writer io.Writer, entry entryInfo) {
 	}
 	line = fmt.Sprintf("%s\t%s", line, entry.ModTime().Format(modTimeFormat))
 
-	line = fmt.Sprintf("%s\t%s", line, entry.SafeName())
+	line = fmt.Sprintf("%s\t%s", line, entry.listName())
 
 	fmt.Fprintln(writer, line)
 }
@@ -291,6 +552,11 @@ func printErrorsAndExit(errs ...error) {
 }
 
 func formatError(err error) string {
+	var wrapped *errLine
+	if errors.As(err, &wrapped) {
+		return wrapped.Error()
+	}
+
 	var pathErr *fs.PathError
 	if errors.As(err, &pathErr) {
 		return fmt.Sprintf("cannot access '%s': %s", pathErr.Path, pathErr.Err)
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/tabwriter"
+
+	"github.com/Rican7/gocoreutils/index"
+)
+
+// listRecursive runs a live -R listing of root through the same
+// fetchEntryInfo/printEntries path main uses, and returns the rendered
+// output.
+func listRecursive(t *testing.T, root string) string {
+	t.Helper()
+
+	old := config
+	t.Cleanup(func() { config = old })
+	config.recursive = true
+
+	info, err := os.Stat(root)
+	if err != nil {
+		t.Fatalf("stat root: %v", err)
+	}
+
+	entry := newEntryInfo(osFS{}, root, info)
+	entry.directRequest = true
+	entries := []entryInfo{entry}
+
+	if errs := fetchEntryInfo(entries, config.recursive); len(errs) > 0 {
+		t.Fatalf("fetchEntryInfo: %v", errs)
+	}
+
+	var buf bytes.Buffer
+	writer := tabwriter.NewWriter(&buf, 0, 0, 1, ' ', 0)
+	printEntries(writer, entries, config.recursive)
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	return buf.String()
+}
+
+// TestPrintEntriesRecursiveNoDuplicates covers a regression where -R
+// printed every file twice: once inline under its directory's header, and
+// again because the recursive printEntries call re-treated the same
+// subEntries as a fresh top-level file list.
+func TestPrintEntriesRecursiveNoDuplicates(t *testing.T) {
+	root := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(root, "a", "b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "b", "deep.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "top.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := listRecursive(t, root)
+
+	for _, name := range []string{"top.txt", "deep.txt"} {
+		if n := strings.Count(out, name); n != 1 {
+			t.Errorf("output contains %q %d times, want exactly 1:\n%s", name, n, out)
+		}
+	}
+}
+
+// TestReindexApplyDiffListRoundTrip exercises --reindex, --apply-diff and
+// an --index listing back to back through the same index.Reindex,
+// index.ApplyDiff and indexEntryFor calls `ls` itself makes for them, the
+// way a real invocation would chain them.
+func TestReindexApplyDiffListRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "keep.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	idxPath := filepath.Join(t.TempDir(), "index")
+
+	if err := index.Reindex(root, idxPath); err != nil {
+		t.Fatalf("Reindex: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "a", "new.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	diffPath := filepath.Join(t.TempDir(), "diff")
+	if err := os.WriteFile(diffPath, []byte("+ a/new.txt\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := index.ApplyDiff(root, idxPath, diffPath); err != nil {
+		t.Fatalf("ApplyDiff: %v", err)
+	}
+
+	entry, err := indexEntryFor(idxPath, "a", false)
+	if err != nil {
+		t.Fatalf("indexEntryFor: %v", err)
+	}
+
+	var names []string
+	for _, child := range entry.subEntries {
+		names = append(names, child.displayName())
+	}
+
+	want := []string{"keep.txt", "new.txt"}
+	if len(names) != len(want) {
+		t.Fatalf("listed names = %v, want %v", names, want)
+	}
+	for i, name := range want {
+		if names[i] != name {
+			t.Fatalf("listed names = %v, want %v", names, want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tarFS adapts a tar archive to fs.FS (plus fs.StatFS, fs.ReadDirFS and
+// OwnerFS), so ls can list inside an archive via "tar://archive.tar!/inner"
+// without extracting it first. A tar stream has no index to seek a member
+// by name, so the whole archive is read into memory once, up front, and
+// served from that in-memory tree from then on.
+type tarFS struct {
+	nodes map[string]*tarNode
+}
+
+type tarNode struct {
+	name     string // full "/"-separated path within the archive, "" for root
+	fileInfo fs.FileInfo
+	data     []byte
+	children []string // base names of direct children, sorted; dirs only
+}
+
+// openTarFS reads archivePath in full and indexes it by path.
+func openTarFS(archivePath string) (*tarFS, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fsys := &tarFS{nodes: map[string]*tarNode{
+		"": {name: "", fileInfo: tarDirInfo("")},
+	}}
+
+	tr := tar.NewReader(f)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+
+		fsys.add(hdr, data)
+	}
+
+	return fsys, nil
+}
+
+// add indexes a single archive member, synthesizing any ancestor
+// directories that ensure creates along the way, since tar archives don't
+// always carry an explicit header for every intermediate directory.
+func (t *tarFS) add(hdr *tar.Header, data []byte) {
+	name := normalizeTarName(hdr.Name)
+	if name == "" {
+		return
+	}
+
+	node := t.ensure(name)
+
+	if hdr.Typeflag == tar.TypeDir || strings.HasSuffix(hdr.Name, "/") {
+		node.fileInfo = tarDirInfo(path.Base(name))
+		return
+	}
+
+	node.fileInfo = tarFileInfo{name: path.Base(name), hdr: hdr}
+	node.data = data
+}
+
+// ensure returns the node for name, creating it (as a directory, until a
+// later add overwrites it with a file's own header) and every ancestor
+// directory it's missing.
+func (t *tarFS) ensure(name string) *tarNode {
+	if node, ok := t.nodes[name]; ok {
+		return node
+	}
+
+	node := &tarNode{name: name, fileInfo: tarDirInfo(path.Base(name))}
+	t.nodes[name] = node
+
+	dir := path.Dir(name)
+	if dir == "." {
+		dir = ""
+	}
+
+	t.ensure(dir).addChild(path.Base(name))
+
+	return node
+}
+
+func (n *tarNode) addChild(base string) {
+	for _, existing := range n.children {
+		if existing == base {
+			return
+		}
+	}
+
+	n.children = append(n.children, base)
+	sort.Strings(n.children)
+}
+
+// normalizeTarName turns a tar member name or an fs.FS-style lookup name
+// into the "/"-separated, no-leading-slash key tarFS.nodes uses, with ""
+// meaning the archive root.
+func normalizeTarName(name string) string {
+	name = path.Clean(strings.TrimPrefix(name, "./"))
+	if name == "." || name == "/" {
+		return ""
+	}
+
+	return strings.TrimPrefix(name, "/")
+}
+
+func (t *tarFS) Open(name string) (fs.File, error) {
+	node, ok := t.nodes[normalizeTarName(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &tarFile{node: node, reader: bytes.NewReader(node.data)}, nil
+}
+
+func (t *tarFS) Stat(name string) (fs.FileInfo, error) {
+	node, ok := t.nodes[normalizeTarName(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return node.fileInfo, nil
+}
+
+func (t *tarFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, ok := t.nodes[normalizeTarName(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries := make([]fs.DirEntry, len(node.children))
+	for i, base := range node.children {
+		child := t.nodes[path.Join(node.name, base)]
+		entries[i] = fs.FileInfoToDirEntry(child.fileInfo)
+	}
+
+	return entries, nil
+}
+
+// Owner reports the uid/gid carried in the tar header for a regular member;
+// directories (real or synthesized) are stored without a header (add
+// discards a real directory's), so they report uid/gid 0 and a single link,
+// like the synthetic root does.
+func (t *tarFS) Owner(name string) (uid, gid, nlink uint32, blocks int64, err error) {
+	node, ok := t.nodes[normalizeTarName(name)]
+	if !ok {
+		return 0, 0, 0, 0, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	fileInfo, ok := node.fileInfo.(tarFileInfo)
+	if !ok {
+		return 0, 0, 1, 0, nil
+	}
+
+	return uint32(fileInfo.hdr.Uid), uint32(fileInfo.hdr.Gid), 1, (fileInfo.hdr.Size + 511) / 512, nil
+}
+
+var (
+	_ fs.FS        = (*tarFS)(nil)
+	_ fs.StatFS    = (*tarFS)(nil)
+	_ fs.ReadDirFS = (*tarFS)(nil)
+	_ OwnerFS      = (*tarFS)(nil)
+)
+
+// tarFile implements fs.File for a single in-memory archive member.
+type tarFile struct {
+	node   *tarNode
+	reader *bytes.Reader
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) { return f.node.fileInfo, nil }
+
+func (f *tarFile) Read(b []byte) (int, error) { return f.reader.Read(b) }
+
+func (f *tarFile) Close() error { return nil }
+
+// tarFileInfo adapts a *tar.Header to fs.FileInfo for a regular archive
+// member.
+type tarFileInfo struct {
+	name string
+	hdr  *tar.Header
+}
+
+func (i tarFileInfo) Name() string       { return i.name }
+func (i tarFileInfo) Size() int64        { return i.hdr.Size }
+func (i tarFileInfo) Mode() fs.FileMode  { return fs.FileMode(i.hdr.Mode) }
+func (i tarFileInfo) ModTime() time.Time { return i.hdr.ModTime }
+func (i tarFileInfo) IsDir() bool        { return false }
+func (i tarFileInfo) Sys() any           { return i.hdr }
+
+// tarDirInfo is the fs.FileInfo for a directory entry, including ones
+// synthesized for an intermediate path that had no explicit header of its
+// own in the archive.
+type tarDirInfo string
+
+func (i tarDirInfo) Name() string       { return string(i) }
+func (i tarDirInfo) Size() int64        { return 0 }
+func (i tarDirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (i tarDirInfo) ModTime() time.Time { return time.Time{} }
+func (i tarDirInfo) IsDir() bool        { return true }
+func (i tarDirInfo) Sys() any           { return nil }
@@ -0,0 +1,255 @@
+package index
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ApplyDiff consumes zfs-diff-style lines from diffPath ("+ path" added,
+// "- path" removed, "M path" modified, "R old new" renamed) and rewrites
+// indexPath accordingly, re-statting only the affected paths under root
+// rather than a full Reindex. Like Reindex, it streams old records into a
+// temp file and atomically renames it into place, so a reader never sees a
+// half-applied diff.
+func ApplyDiff(root, indexPath, diffPath string) error {
+	diffFile, err := os.Open(diffPath)
+	if err != nil {
+		return err
+	}
+	defer diffFile.Close()
+
+	removed, modified, renames, toAdd, err := parseDiff(diffFile)
+	if err != nil {
+		return err
+	}
+
+	renamedDirs, toAdd, err := resolveRenames(root, renames, removed, toAdd)
+	if err != nil {
+		return err
+	}
+
+	old, err := os.Open(indexPath)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(indexPath), ".index-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if err := streamDiff(root, old, tmp, removed, renamedDirs, modified, toAdd); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, indexPath)
+}
+
+func streamDiff(root string, old io.Reader, w io.Writer, removed map[string]bool, renamedDirs []string, modified map[string]bool, toAdd []string) error {
+	reader := bufio.NewReader(old)
+	writer := bufio.NewWriter(w)
+
+	addIdx := 0
+	// flushAddsBefore writes every not-yet-written toAdd entry that sorts
+	// before bound (in dfsKey order), or all of the remaining ones when
+	// unbounded is true. unbounded is its own parameter rather than a
+	// sentinel bound value: the root record's own Name is "", the same
+	// value the final, no-bound call would otherwise need to mean "flush
+	// everything", and confusing the two would write every root-level
+	// addition ahead of the root record itself.
+	flushAddsBefore := func(bound string, unbounded bool) error {
+		for addIdx < len(toAdd) && (unbounded || dfsKey(toAdd[addIdx]) < dfsKey(bound)) {
+			if err := writeLiveRecord(writer, root, toAdd[addIdx]); err != nil {
+				return err
+			}
+			addIdx++
+		}
+
+		return nil
+	}
+
+	for {
+		rec, err := ReadRecord(reader)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := flushAddsBefore(rec.Name, false); err != nil {
+			return err
+		}
+
+		switch {
+		case removed[rec.Name] || underAny(renamedDirs, rec.Name):
+			continue
+		case modified[rec.Name]:
+			if err := writeLiveRecord(writer, root, rec.Name); err != nil {
+				return err
+			}
+		default:
+			if err := WriteRecord(writer, rec); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := flushAddsBefore("", true); err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
+
+func writeLiveRecord(w io.Writer, root, name string) error {
+	info, err := os.Lstat(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+
+	rec, _ := recordFor(name, info)
+
+	return WriteRecord(w, rec)
+}
+
+// rename is a parsed "R old new" diff line. Whether it moved a single file
+// or a whole directory subtree isn't decidable from the diff line itself
+// (zfs diff reports a directory rename as one line, the same as a file
+// rename) — resolveRenames settles that by stat-ing new on the live
+// filesystem.
+type rename struct {
+	old, new string
+}
+
+// parseDiff turns zfs-diff-style lines into the set of names removed, the
+// set modified in place, the renames, and the plain additions. toAdd isn't
+// sorted here: resolveRenames appends each rename's expansion to it and
+// sorts the combined list once, in dfsKey order, the same on-disk order
+// streamDiff needs to interleave them with the unchanged records.
+func parseDiff(r io.Reader) (removed, modified map[string]bool, renames []rename, toAdd []string, err error) {
+	removed = make(map[string]bool)
+	modified = make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		name := filepath.ToSlash(fields[1])
+
+		switch fields[0] {
+		case "+":
+			toAdd = append(toAdd, name)
+		case "-":
+			removed[name] = true
+		case "M":
+			modified[name] = true
+		case "R":
+			if len(fields) < 3 {
+				continue
+			}
+
+			renames = append(renames, rename{old: name, new: filepath.ToSlash(fields[2])})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	return removed, modified, renames, toAdd, nil
+}
+
+// resolveRenames expands each "R old new" line into the removals and
+// additions streamDiff needs to actually apply it. A renamed file is just a
+// single record moving from old to new, so it's added to removed and toAdd
+// same as parseDiff used to do directly. A renamed directory takes its
+// whole subtree of descendant records with it, though, and zfs diff reports
+// only the directory's own rename, not each child — so resolveRenames walks
+// new's live subtree (which is where the moved directory now lives) to
+// pick up every descendant that needs to move too, and returns old as a
+// prefix so streamDiff can drop every stale old/... record, not just the
+// one exact match.
+func resolveRenames(root string, renames []rename, removed map[string]bool, toAdd []string) ([]string, []string, error) {
+	var renamedDirs []string
+
+	for _, ren := range renames {
+		newPath := filepath.Join(root, filepath.FromSlash(ren.new))
+
+		info, err := os.Lstat(newPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !info.IsDir() {
+			removed[ren.old] = true
+			toAdd = append(toAdd, ren.new)
+			continue
+		}
+
+		renamedDirs = append(renamedDirs, ren.old)
+
+		err = filepath.WalkDir(newPath, func(path string, _ fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(newPath, path)
+			if err != nil {
+				return err
+			}
+
+			name := ren.new
+			if rel != "." {
+				name += "/" + filepath.ToSlash(rel)
+			}
+
+			toAdd = append(toAdd, name)
+
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	sort.Slice(toAdd, func(i, j int) bool {
+		return dfsKey(toAdd[i]) < dfsKey(toAdd[j])
+	})
+
+	return renamedDirs, toAdd, nil
+}
+
+// underAny reports whether name lies under any of prefixes, in the same
+// sense Query uses for a directory's subtree: the prefix itself or
+// anything nested under it.
+func underAny(prefixes []string, name string) bool {
+	for _, p := range prefixes {
+		if matchesPrefix(p, name) {
+			return true
+		}
+	}
+
+	return false
+}
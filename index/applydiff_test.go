@@ -0,0 +1,85 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// readAllRecords reads every record out of the index at idxPath, in
+// on-disk (DFS) order.
+func readAllRecords(t *testing.T, idxPath string) []Record {
+	t.Helper()
+
+	f, err := os.Open(idxPath)
+	if err != nil {
+		t.Fatalf("open index: %v", err)
+	}
+	defer f.Close()
+
+	var recs []Record
+	for {
+		rec, err := ReadRecord(f)
+		if err != nil {
+			break
+		}
+		recs = append(recs, rec)
+	}
+
+	return recs
+}
+
+// TestApplyDiffRenameDirectoryMovesDescendants covers "R old new" for a
+// directory: every record under old, not just old itself, must end up
+// under new afterward, and nothing should be left behind under a stale
+// old/... name.
+func TestApplyDiffRenameDirectoryMovesDescendants(t *testing.T) {
+	root := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(root, "a", "b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "b", "deep.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "sibling.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	idxPath := buildIndex(t, root)
+
+	// Apply the rename on the live filesystem first, the way a real
+	// snapshot-to-snapshot diff would already reflect it, then hand
+	// ApplyDiff a diff describing what happened.
+	if err := os.Rename(filepath.Join(root, "a"), filepath.Join(root, "z")); err != nil {
+		t.Fatal(err)
+	}
+
+	diffPath := filepath.Join(t.TempDir(), "diff")
+	if err := os.WriteFile(diffPath, []byte("R a z\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ApplyDiff(root, idxPath, diffPath); err != nil {
+		t.Fatalf("ApplyDiff: %v", err)
+	}
+
+	var names []string
+	for _, rec := range readAllRecords(t, idxPath) {
+		names = append(names, rec.Name)
+	}
+	sort.Strings(names)
+
+	want := []string{"", "z", "z/b", "z/b/deep.txt", "z/sibling.txt"}
+	sort.Strings(want)
+
+	if len(names) != len(want) {
+		t.Fatalf("records after rename = %v, want %v", names, want)
+	}
+	for i, name := range want {
+		if names[i] != name {
+			t.Fatalf("records after rename = %v, want %v", names, want)
+		}
+	}
+}
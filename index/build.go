@@ -0,0 +1,113 @@
+package index
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Build walks root with filepath.WalkDir and writes one Record per entry
+// (root itself included) to w, in depth-first order, sorted alphabetically
+// within each directory. Writing in this order is what lets Query answer a
+// prefix lookup with a single bounded sequential scan: every record whose
+// Name lies inside a directory's subtree is contiguous in the file,
+// immediately following that directory's own record.
+//
+// If visit is non-nil, it's called with every record as it's written along
+// with its on-disk block count (which Record itself doesn't carry), so
+// callers (like Reindex, for its dirsize sidecar) can derive their own
+// summaries in the same pass instead of re-reading the index afterward.
+func Build(root string, w io.Writer, visit func(rec Record, blocks int64)) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			rel = ""
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		rec, blocks := recordFor(filepath.ToSlash(rel), info)
+
+		if err := WriteRecord(w, rec); err != nil {
+			return err
+		}
+
+		if visit != nil {
+			visit(rec, blocks)
+		}
+
+		return nil
+	})
+}
+
+func recordFor(name string, info fs.FileInfo) (Record, int64) {
+	rec := Record{
+		Name:  name,
+		Size:  info.Size(),
+		MTime: info.ModTime(),
+		Mode:  uint32(info.Mode()),
+	}
+
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		rec.Type = TypeSymlink
+	case info.IsDir():
+		rec.Type = TypeDir
+	default:
+		rec.Type = TypeFile
+	}
+
+	var blocks int64
+
+	if sysStat, ok := info.Sys().(*syscall.Stat_t); ok {
+		rec.UID = sysStat.Uid
+		rec.GID = sysStat.Gid
+		blocks = sysStat.Blocks
+	}
+
+	return rec, blocks
+}
+
+// Reindex rebuilds the index for root and atomically replaces indexPath
+// (and its dirsize sidecar) with the result, so that readers never observe
+// a partially-written database.
+func Reindex(root, indexPath string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(indexPath), ".index-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // no-op once the rename below succeeds
+
+	sizes := newDirSizeAccumulator()
+
+	if err := func() error {
+		defer tmp.Close()
+
+		if err := Build(root, tmp, sizes.add); err != nil {
+			return err
+		}
+
+		return tmp.Sync()
+	}(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, indexPath); err != nil {
+		return err
+	}
+
+	return writeDirSizes(dirSizePath(indexPath), sizes.totals())
+}
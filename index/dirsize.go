@@ -0,0 +1,174 @@
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// dirSizePath derives the sidecar path from the main index's path.
+func dirSizePath(indexPath string) string {
+	return indexPath + ".dirsize"
+}
+
+// dirSizeAccumulator sums the block count of the entries directly inside
+// each directory seen during a Build pass (the same one-level quantity `ls
+// -l`'s "total" line reports), keeping only O(depth) running totals open at
+// once: since Build visits records in depth-first order, a directory's total
+// is complete as soon as Build moves on to one of that directory's siblings
+// (or finishes), at which point it's popped off the stack and recorded. The
+// recorded totals, one per directory, are all kept until totals is called.
+type dirSizeAccumulator struct {
+	stack []dirSizeFrame
+	done  map[string]int64
+}
+
+type dirSizeFrame struct {
+	name  string
+	total int64
+}
+
+func newDirSizeAccumulator() *dirSizeAccumulator {
+	return &dirSizeAccumulator{done: make(map[string]int64)}
+}
+
+func (a *dirSizeAccumulator) add(rec Record, blocks int64) {
+	for len(a.stack) > 0 && !isWithin(a.stack[len(a.stack)-1].name, rec.Name) {
+		a.pop()
+	}
+
+	if len(a.stack) > 0 {
+		a.stack[len(a.stack)-1].total += blocks
+	}
+
+	if rec.Type == TypeDir {
+		a.stack = append(a.stack, dirSizeFrame{name: rec.Name})
+	}
+}
+
+func (a *dirSizeAccumulator) pop() {
+	top := a.stack[len(a.stack)-1]
+	a.stack = a.stack[:len(a.stack)-1]
+	a.done[top.name] = top.total
+}
+
+func (a *dirSizeAccumulator) totals() map[string]int64 {
+	for len(a.stack) > 0 {
+		a.pop()
+	}
+
+	return a.done
+}
+
+// isWithin reports whether child names dir itself or something inside it.
+func isWithin(dir, child string) bool {
+	return dir == "" || child == dir || strings.HasPrefix(child, dir+"/")
+}
+
+// writeDirSizes writes the sidecar in the same (uint16 BE name-length, name,
+// int64 BE total-blocks) shape as the main index, sorted byte-wise by name
+// (not in the main index's depth-first order), so LookupDirSize can stop its
+// scan at the first name that sorts after the one it wants.
+func writeDirSizes(sidecarPath string, totals map[string]int64) error {
+	tmp, err := os.CreateTemp(path.Dir(sidecarPath), ".dirsize-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	names := make([]string, 0, len(totals))
+	for name := range totals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := bufio.NewWriter(tmp)
+	for _, name := range names {
+		if err := writeDirSizeEntry(w, name, totals[name]); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, sidecarPath)
+}
+
+func writeDirSizeEntry(w io.Writer, name string, total int64) error {
+	nameBytes := []byte(name)
+
+	header := make([]byte, 2+len(nameBytes))
+	binary.BigEndian.PutUint16(header, uint16(len(nameBytes)))
+	copy(header[2:], nameBytes)
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	var totalBytes [8]byte
+	binary.BigEndian.PutUint64(totalBytes[:], uint64(total))
+
+	_, err := w.Write(totalBytes[:])
+
+	return err
+}
+
+// LookupDirSize returns the precomputed total block count for dir, as
+// written by the last Reindex, by scanning its sidecar file.
+func LookupDirSize(indexPath, dir string) (int64, error) {
+	f, err := os.Open(dirSizePath(indexPath))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	for {
+		var nameLen [2]byte
+		if _, err := io.ReadFull(r, nameLen[:]); err != nil {
+			if err == io.EOF {
+				return 0, os.ErrNotExist
+			}
+
+			return 0, err
+		}
+
+		nameBytes := make([]byte, binary.BigEndian.Uint16(nameLen[:]))
+		if _, err := io.ReadFull(r, nameBytes); err != nil {
+			return 0, err
+		}
+
+		var totalBytes [8]byte
+		if _, err := io.ReadFull(r, totalBytes[:]); err != nil {
+			return 0, err
+		}
+
+		name := string(nameBytes)
+		if name == dir {
+			return int64(binary.BigEndian.Uint64(totalBytes[:])), nil
+		}
+
+		// Sidecar entries are sorted byte-wise by name: once we're
+		// past dir's position, it isn't there.
+		if name > dir && !isWithin(dir, name) {
+			return 0, os.ErrNotExist
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package index
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// AllDepths tells Query to materialize prefix's entire subtree, however
+// deep, which is what a recursive (-R) listing needs.
+const AllDepths = -1
+
+// Query answers a directory listing from a single sequential scan of
+// indexPath, stopping as soon as it passes the last record prefix could
+// possibly match. It never loads the whole file into memory: records
+// outside prefix's subtree are never even read past, and within the
+// subtree only those at most maxDepth levels below prefix are kept (pass
+// AllDepths for a recursive listing that needs the whole subtree; a plain,
+// non-recursive listing only ever needs maxDepth 1 — prefix itself plus its
+// direct children — however large the subtree underneath is). prefix is the
+// indexed (root-relative, "/"-separated) path of the directory being
+// listed; pass "" for the indexed root itself.
+func Query(indexPath, prefix string, maxDepth int) ([]Record, error) {
+	f, err := os.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	var matches []Record
+
+	inSubtree := false
+
+	for {
+		rec, err := ReadRecord(r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if matchesPrefix(prefix, rec.Name) {
+			inSubtree = true
+
+			if maxDepth == AllDepths || depthBelow(prefix, rec.Name) <= maxDepth {
+				matches = append(matches, rec)
+			}
+
+			continue
+		}
+
+		// Records are written depth-first, so once we're past a name
+		// that could still belong under prefix, nothing later in the
+		// file can either. Compare by dfsKey, not raw Name: a plain
+		// string compare disagrees with on-disk order whenever a
+		// sibling name sorts between a directory and its own subtree
+		// (e.g. prefix "a.conf" against on-disk record "a/z").
+		if inSubtree || dfsKey(rec.Name) > dfsKey(prefix) {
+			break
+		}
+	}
+
+	return matches, nil
+}
+
+func matchesPrefix(prefix, name string) bool {
+	if prefix == "" {
+		return true
+	}
+
+	return name == prefix || strings.HasPrefix(name, prefix+"/")
+}
+
+// depthBelow counts how many path components name lies below prefix; 0 for
+// prefix itself, 1 for a direct child, and so on.
+func depthBelow(prefix, name string) int {
+	rel := strings.TrimPrefix(name, prefix)
+	rel = strings.TrimPrefix(rel, "/")
+
+	if rel == "" {
+		return 0
+	}
+
+	return strings.Count(rel, "/") + 1
+}
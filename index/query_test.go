@@ -0,0 +1,129 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildIndex walks root and writes the resulting index to a temp file,
+// returning its path.
+func buildIndex(t *testing.T, root string) string {
+	t.Helper()
+
+	idxPath := filepath.Join(t.TempDir(), "index")
+
+	f, err := os.Create(idxPath)
+	if err != nil {
+		t.Fatalf("create index: %v", err)
+	}
+	defer f.Close()
+
+	if err := Build(root, f, nil); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	return idxPath
+}
+
+// TestQueryPrefixCollidingSibling covers the case where a directory's
+// on-disk subtree (written depth-first) sorts, byte-for-byte, after a
+// sibling name that should come later in a plain lexicographic listing
+// (e.g. "a.conf" < "a/z"). Query must not mistake that sibling for being
+// past prefix's slot in the file.
+func TestQueryPrefixCollidingSibling(t *testing.T) {
+	root := t.TempDir()
+
+	if err := os.Mkdir(filepath.Join(root, "a"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "z"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a.conf"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	idxPath := buildIndex(t, root)
+
+	matches, err := Query(idxPath, "a.conf", AllDepths)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+
+	if len(matches) != 1 || matches[0].Name != "a.conf" {
+		t.Fatalf("Query(%q) = %+v, want a single match named %q", "a.conf", matches, "a.conf")
+	}
+}
+
+func TestQueryDirectorySubtree(t *testing.T) {
+	root := t.TempDir()
+
+	if err := os.Mkdir(filepath.Join(root, "a"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "z"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a.conf"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	idxPath := buildIndex(t, root)
+
+	matches, err := Query(idxPath, "a", AllDepths)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+
+	var names []string
+	for _, rec := range matches {
+		names = append(names, rec.Name)
+	}
+
+	want := []string{"a", "a/z"}
+	if len(names) != len(want) || names[0] != want[0] || names[1] != want[1] {
+		t.Fatalf("Query(%q) names = %v, want %v", "a", names, want)
+	}
+}
+
+// TestQueryMaxDepthBoundsMemory covers the common, non-recursive listing
+// path: querying the root with maxDepth 1 must return only the root and its
+// direct children, never a grandchild several directories deep, however
+// large that subtree is. This is what keeps a plain `ls --index=PATH` from
+// loading the entire database into memory.
+func TestQueryMaxDepthBoundsMemory(t *testing.T) {
+	root := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(root, "a", "b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "b", "deep.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "top.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	idxPath := buildIndex(t, root)
+
+	matches, err := Query(idxPath, "", 1)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+
+	var names []string
+	for _, rec := range matches {
+		names = append(names, rec.Name)
+	}
+
+	want := []string{"", "a", "top.txt"}
+	if len(names) != len(want) {
+		t.Fatalf("Query(\"\", maxDepth=1) names = %v, want %v", names, want)
+	}
+	for i, name := range want {
+		if names[i] != name {
+			t.Fatalf("Query(\"\", maxDepth=1) names = %v, want %v", names, want)
+		}
+	}
+}
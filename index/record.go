@@ -0,0 +1,153 @@
+// Package index implements a persistent, on-disk directory listing database
+// for ls, so that `ls --index=PATH` can answer a listing from a single
+// sequential scan of a flat file instead of walking (or fully loading) the
+// live filesystem.
+package index
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// EntryType identifies the kind of file a Record describes.
+type EntryType byte
+
+// The set of EntryTypes a Record can hold.
+const (
+	TypeFile EntryType = iota
+	TypeDir
+	TypeSymlink
+)
+
+// Record is a single entry in an index file: everything printLongEntryInfo
+// needs to render an `ls -l` line, without touching the live filesystem.
+type Record struct {
+	// Name is the entry's path relative to the indexed root, using "/"
+	// as the separator regardless of host OS. Records are written in
+	// depth-first order (see Build), which is NOT the same as a plain
+	// byte-wise sort of Name: a directory's whole subtree is contiguous
+	// immediately after the directory's own record, even when a sibling
+	// name would otherwise sort between them (e.g. "a.conf" < "a/z"
+	// byte-wise, but "a/z" is written first because it's inside "a").
+	// Code that needs to compare two Names in on-disk order must use
+	// dfsKey, not a raw string comparison.
+	Name string
+
+	Type  EntryType
+	Size  int64
+	MTime time.Time
+	UID   uint32
+	GID   uint32
+	Mode  uint32
+}
+
+// ErrCorruptRecord is returned when a record's on-disk encoding is
+// truncated or otherwise malformed.
+var ErrCorruptRecord = errors.New("index: corrupt record")
+
+// dfsKey maps a Record.Name to a string whose ordinary byte-wise ordering
+// matches the depth-first order Build writes records in. "/" sorts before
+// every other byte a filename can contain (including "." and "-", which
+// both sort before "/" in plain ASCII), so a directory's record is always
+// immediately followed by its whole subtree rather than by a sibling whose
+// name merely looks smaller byte-for-byte.
+func dfsKey(name string) string {
+	return strings.ReplaceAll(name, "/", "\x00")
+}
+
+// WriteRecord appends rec to w in the on-disk format:
+//
+//	uint16 BE name-length
+//	name bytes
+//	type byte
+//	int64 BE size
+//	int64 BE mtime (unix seconds)
+//	uint32 BE uid
+//	uint32 BE gid
+//	uint32 BE mode
+func WriteRecord(w io.Writer, rec Record) error {
+	nameBytes := []byte(rec.Name)
+	if len(nameBytes) > 0xFFFF {
+		return errors.New("index: name too long")
+	}
+
+	header := make([]byte, 2+len(nameBytes)+1)
+	binary.BigEndian.PutUint16(header, uint16(len(nameBytes)))
+	copy(header[2:], nameBytes)
+	header[2+len(nameBytes)] = byte(rec.Type)
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	var fixed [20]byte
+	binary.BigEndian.PutUint64(fixed[0:8], uint64(rec.Size))
+	binary.BigEndian.PutUint64(fixed[8:16], uint64(rec.MTime.Unix()))
+	binary.BigEndian.PutUint32(fixed[16:20], rec.UID)
+
+	var tail [8]byte
+	binary.BigEndian.PutUint32(tail[0:4], rec.GID)
+	binary.BigEndian.PutUint32(tail[4:8], rec.Mode)
+
+	if _, err := w.Write(fixed[:]); err != nil {
+		return err
+	}
+
+	_, err := w.Write(tail[:])
+
+	return err
+}
+
+// ReadRecord reads the next record from r. It returns io.EOF (unwrapped)
+// when r is exhausted between records.
+func ReadRecord(r io.Reader) (Record, error) {
+	var nameLen [2]byte
+	if _, err := io.ReadFull(r, nameLen[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return Record{}, ErrCorruptRecord
+		}
+
+		return Record{}, err
+	}
+
+	nameBytes := make([]byte, binary.BigEndian.Uint16(nameLen[:]))
+	if _, err := io.ReadFull(r, nameBytes); err != nil {
+		return Record{}, corruptOrErr(err)
+	}
+
+	var typeByte [1]byte
+	if _, err := io.ReadFull(r, typeByte[:]); err != nil {
+		return Record{}, corruptOrErr(err)
+	}
+
+	var fixed [20]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return Record{}, corruptOrErr(err)
+	}
+
+	var tail [8]byte
+	if _, err := io.ReadFull(r, tail[:]); err != nil {
+		return Record{}, corruptOrErr(err)
+	}
+
+	return Record{
+		Name:  string(nameBytes),
+		Type:  EntryType(typeByte[0]),
+		Size:  int64(binary.BigEndian.Uint64(fixed[0:8])),
+		MTime: time.Unix(int64(binary.BigEndian.Uint64(fixed[8:16])), 0),
+		UID:   binary.BigEndian.Uint32(fixed[16:20]),
+		GID:   binary.BigEndian.Uint32(tail[0:4]),
+		Mode:  binary.BigEndian.Uint32(tail[4:8]),
+	}, nil
+}
+
+func corruptOrErr(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrCorruptRecord
+	}
+
+	return err
+}
@@ -0,0 +1,42 @@
+package index
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWriteReadRecordRoundTrip(t *testing.T) {
+	want := Record{
+		Name:  "a/b/c.txt",
+		Type:  TypeSymlink,
+		Size:  4096,
+		MTime: time.Unix(1700000000, 0),
+		UID:   1000,
+		GID:   1000,
+		Mode:  0644,
+	}
+
+	var buf bytes.Buffer
+	if err := WriteRecord(&buf, want); err != nil {
+		t.Fatalf("WriteRecord: %v", err)
+	}
+
+	got, err := ReadRecord(&buf)
+	if err != nil {
+		t.Fatalf("ReadRecord: %v", err)
+	}
+
+	if got != want {
+		t.Fatalf("round trip mismatch:\n got  %+v\n want %+v", got, want)
+	}
+}
+
+func TestReadRecordEOF(t *testing.T) {
+	var buf bytes.Buffer
+
+	if _, err := ReadRecord(&buf); err != io.EOF {
+		t.Fatalf("ReadRecord on an empty buffer: err = %v, want io.EOF", err)
+	}
+}